@@ -16,14 +16,33 @@
 package web
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
+	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/adk/artifactservice"
 	"google.golang.org/adk/cmd/restapi/config"
 	"google.golang.org/adk/cmd/restapi/services"
@@ -31,76 +50,359 @@ import (
 	"google.golang.org/adk/sessionservice"
 )
 
+// defaultShutdownTimeout bounds how long Serve waits for in-flight requests
+// to drain once a shutdown is requested.
+const defaultShutdownTimeout = 15 * time.Second
+
 // WebConfig is a struct with parameters to run a WebServer.
 type WebConfig struct {
+	// Host is the address Serve binds to. Defaults to 127.0.0.1.
+	Host string
+	// LocalPort is the port Serve binds to. A value of 0 makes Serve pick a
+	// free port, which is then reported via the logged listening address.
 	LocalPort      int
 	UIDistPath     string
 	FrontEndServer string
 	StartRestApi   bool
 	StartWebUI     bool
+
+	// WebUIProxy, when set, makes '/ui/' reverse-proxy to this URL (e.g.
+	// http://localhost:4200) instead of serving UIDistPath from disk, so a
+	// front-end dev server can be used with live reload. Mutually exclusive
+	// with UIDistPath.
+	WebUIProxy string
+
+	// Open, when true, launches the default browser at the resolved web UI
+	// URL once the listener is ready.
+	Open bool
+
+	// AllowedOrigins is the set of origins allowed to make CORS requests to
+	// '/api'. When empty, FrontEndServer is used as the sole allowed origin.
+	AllowedOrigins []string
+	// UnsafeCORS, when true, allows any origin to make CORS requests to
+	// '/api' and disables credentialed requests. Intended for local
+	// development only; never enable it in production.
+	UnsafeCORS bool
+	// AllowedHeaders, ExposedHeaders, and MaxAge are forwarded to the
+	// underlying cors.Options to let downstream apps tune preflight caching.
+	AllowedHeaders []string
+	ExposedHeaders []string
+	MaxAge         int
+
+	// StartGraphQL mounts a GraphQL endpoint at '/graphql', backed by
+	// ServeConfig.GraphQLSchema, exposing sessions, agents, and artifacts
+	// alongside the REST API.
+	StartGraphQL bool
+	// StartPlayground mounts a GraphQL playground at '/playground' pointed at
+	// '/graphql'. Has no effect unless StartGraphQL is also set.
+	StartPlayground bool
+
+	// LogFormat selects the access log line format: "logfmt" (the default,
+	// used when empty) or "json".
+	LogFormat string
+
+	// ReadTimeout, WriteTimeout, IdleTimeout, and ReadHeaderTimeout are
+	// forwarded to the underlying http.Server. Zero leaves the http.Server
+	// default: no timeout for ReadTimeout and WriteTimeout, and the value of
+	// ReadTimeout for IdleTimeout and ReadHeaderTimeout.
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+
+	// ShutdownTimeout bounds how long Serve waits for in-flight requests to
+	// finish after a shutdown is requested. Defaults to defaultShutdownTimeout
+	// when zero.
+	ShutdownTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile, when both set, make Serve listen with TLS
+	// using the given certificate and key.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// AutoCertDomains, when non-empty, makes Serve obtain and renew a TLS
+	// certificate for the given domains via Let's Encrypt instead of using
+	// TLSCertFile/TLSKeyFile. AutoCertCacheDir, if set, is used to persist
+	// issued certificates across restarts.
+	AutoCertDomains  []string
+	AutoCertCacheDir string
 }
 
 // ParseArgs parses the arguments for the ADK API server.
 func ParseArgs() *WebConfig {
-	localPortFlag := flag.Int("port", 8080, "Port to listen on")
+	hostFlag := flag.String("host", "127.0.0.1", "Host to listen on")
+	localPortFlag := flag.Int("port", 8080, "Port to listen on; 0 picks a free port")
 	frontendServerFlag := flag.String("front_address", "http://localhost:8001", "Front address to allow CORS requests from")
 	startRespApi := flag.Bool("start_restapi", true, "Set to start a rest api endpoint '/api'")
 	startWebUI := flag.Bool("start_webui", true, "Set to start a web ui endpoint '/ui'")
 	webuiDist := flag.String("webui_path", "", "Points to a static web ui dist path with the built version of ADK Web UI")
+	webuiProxy := flag.String("webui_proxy", "", "Reverse-proxies '/ui/' to this URL (e.g. http://localhost:4200) instead of serving webui_path; mutually exclusive with webui_path")
+	tlsCertFile := flag.String("tls_cert_file", "", "Path to a TLS certificate file; requires tls_key_file")
+	tlsKeyFile := flag.String("tls_key_file", "", "Path to a TLS private key file; requires tls_cert_file")
+	open := flag.Bool("open", false, "Open the default browser at the web ui once the server is listening")
+	allowedOrigins := flag.String("allowed_origins", "", "Comma-separated list of origins allowed to make CORS requests to '/api'; defaults to front_address")
+	unsafeCors := flag.Bool("unsafe_cors", false, "Allow CORS requests to '/api' from any origin, without credentials; development only")
+	startGraphQL := flag.Bool("start_graphql", false, "Set to start a GraphQL endpoint '/graphql'")
+	startPlayground := flag.Bool("start_playground", false, "Set to start a GraphQL playground '/playground'; requires start_graphql")
+	logFormat := flag.String("log_format", "logfmt", "Access log line format: logfmt or json")
 
 	flag.Parse()
 	if !flag.Parsed() {
 		flag.Usage()
 		panic("Failed to parse flags")
 	}
+	if *webuiDist != "" && *webuiProxy != "" {
+		flag.Usage()
+		panic("webui_path and webui_proxy are mutually exclusive")
+	}
+	var origins []string
+	if *allowedOrigins != "" {
+		origins = strings.Split(*allowedOrigins, ",")
+	}
 	return &(WebConfig{
-		LocalPort:      *localPortFlag,
-		FrontEndServer: *frontendServerFlag,
-		StartRestApi:   *startRespApi,
-		StartWebUI:     *startWebUI,
-		UIDistPath:     *webuiDist,
+		Host:            *hostFlag,
+		LocalPort:       *localPortFlag,
+		FrontEndServer:  *frontendServerFlag,
+		StartRestApi:    *startRespApi,
+		StartWebUI:      *startWebUI,
+		UIDistPath:      *webuiDist,
+		WebUIProxy:      *webuiProxy,
+		TLSCertFile:     *tlsCertFile,
+		TLSKeyFile:      *tlsKeyFile,
+		Open:            *open,
+		AllowedOrigins:  origins,
+		UnsafeCORS:      *unsafeCors,
+		StartGraphQL:    *startGraphQL,
+		StartPlayground: *startPlayground,
+		LogFormat:       *logFormat,
 	})
 }
 
-func Logger(inner http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+// requestIDHeader is the header used to propagate a request ID to and from
+// clients.
+const requestIDHeader = "X-Request-ID"
 
-		inner.ServeHTTP(w, r)
+type contextKey string
 
-		log.Printf(
-			"%s %s %s",
-			r.Method,
-			r.RequestURI,
-			time.Since(start),
-		)
+// requestIDContextKey is the context.Context key under which RequestID
+// stores the current request's ID.
+const requestIDContextKey contextKey = "requestID"
+
+// RequestID is middleware that assigns each request an ID, honoring an
+// incoming X-Request-ID header or generating a UUID otherwise. The ID is
+// stored in the request context (retrievable via RequestIDFromContext) and
+// echoed back in the response header so REST API errors can be traced
+// end-to-end.
+func RequestID(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		inner.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequestIDFromContext returns the request ID stored by RequestID, or "" if
+// none is present.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code and
+// number of bytes written, neither of which is otherwise observable by
+// middleware running after the handler. Embedding http.ResponseWriter only
+// promotes that interface's own methods, so Hijack and Flush are forwarded
+// explicitly to keep statusRecorder from breaking callers that type-assert
+// for http.Hijacker (WebSocket upgrades, e.g. the /graphql subscription
+// transport and the webui_proxy reverse proxy) or http.Flusher (SSE).
+//
+// Once Hijack succeeds, the caller owns the raw connection and can write
+// anything to it (including a non-101 response) without going through
+// WriteHeader/Write, so status and bytes can no longer be trusted; hijacked
+// records that so NewLogger can log a "hijacked" marker instead of a
+// fabricated 200.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int
+	hijacked bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support http.Hijacker")
+	}
+	conn, buf, err := hj.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, buf, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// NewLogger returns middleware that logs each request's method, route,
+// status, size, and duration, along with remote addr, user agent, referer,
+// and request ID, as either logfmt or JSON lines depending on format
+// ("json" selects JSON; anything else, including "", selects logfmt).
+func NewLogger(format string) mux.MiddlewareFunc {
+	return func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+
+			inner.ServeHTTP(rec, r)
+
+			route := r.URL.Path
+			if rt := mux.CurrentRoute(r); rt != nil {
+				if tpl, err := rt.GetPathTemplate(); err == nil {
+					route = tpl
+				}
+			}
+
+			// Once hijacked, whatever was written to the raw connection is
+			// invisible to statusRecorder, so status is reported as
+			// "hijacked" rather than a fabricated 200.
+			status := "hijacked"
+			if !rec.hijacked {
+				s := rec.status
+				if s == 0 {
+					s = http.StatusOK
+				}
+				status = strconv.Itoa(s)
+			}
+
+			entry := struct {
+				Method     string `json:"method"`
+				Route      string `json:"route"`
+				URI        string `json:"uri"`
+				Status     string `json:"status"`
+				Bytes      int    `json:"bytes"`
+				Duration   string `json:"duration"`
+				RemoteAddr string `json:"remote_addr"`
+				UserAgent  string `json:"user_agent"`
+				Referer    string `json:"referer"`
+				RequestID  string `json:"request_id"`
+			}{
+				Method:     r.Method,
+				Route:      route,
+				URI:        r.RequestURI,
+				Status:     status,
+				Bytes:      rec.bytes,
+				Duration:   time.Since(start).String(),
+				RemoteAddr: r.RemoteAddr,
+				UserAgent:  r.UserAgent(),
+				Referer:    r.Referer(),
+				RequestID:  RequestIDFromContext(r.Context()),
+			}
+
+			if format == "json" {
+				if line, err := json.Marshal(entry); err == nil {
+					log.Println(string(line))
+				}
+				return
+			}
+
+			log.Printf(
+				"method=%s route=%q uri=%q status=%s bytes=%d duration=%s remote_addr=%q user_agent=%q referer=%q request_id=%s",
+				entry.Method, entry.Route, entry.URI, entry.Status, entry.Bytes, entry.Duration, entry.RemoteAddr, entry.UserAgent, entry.Referer, entry.RequestID,
+			)
+		})
+	}
+}
+
 type ServeConfig struct {
 	SessionService  sessionservice.Service
 	AgentLoader     services.AgentLoader
 	ArtifactService artifactservice.Service
+
+	// GraphQLSchema is the gqlgen-generated executable schema wired to
+	// SessionService, AgentLoader, and ArtifactService. Required when
+	// WebConfig.StartGraphQL is set.
+	GraphQLSchema graphql.ExecutableSchema
 }
 
-// Serve initiates the http server and starts it according to WebConfig parameters
-func Serve(c *WebConfig, serveConfig *ServeConfig) {
+// corsOptions builds the cors.Options used to guard '/api' from c. When
+// c.UnsafeCORS is set, it allows any origin and disables credentialed
+// requests, logging a warning since this is intended for development only.
+func corsOptions(c *WebConfig) cors.Options {
+	if c.UnsafeCORS {
+		log.Printf("warning: unsafe CORS enabled, allowing requests to '/api' from any origin; do not use in production")
+		return cors.Options{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodOptions, http.MethodDelete, http.MethodPut},
+			AllowedHeaders: c.AllowedHeaders,
+			ExposedHeaders: c.ExposedHeaders,
+			MaxAge:         c.MaxAge,
+		}
+	}
+
+	origins := c.AllowedOrigins
+	if len(origins) == 0 {
+		origins = []string{c.FrontEndServer}
+	}
+	return cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodOptions, http.MethodDelete, http.MethodPut},
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		MaxAge:           c.MaxAge,
+		AllowCredentials: true,
+	}
+}
+
+// buildRouter assembles the mux.Router serving the web UI and REST API
+// according to c and serveConfig.
+func buildRouter(c *WebConfig, serveConfig *ServeConfig) (*mux.Router, error) {
 	serverConfig := config.ADKAPIRouterConfigs{
 		SessionService:  serveConfig.SessionService,
 		AgentLoader:     serveConfig.AgentLoader,
 		ArtifactService: serveConfig.ArtifactService,
 	}
-	serverConfig.Cors = *cors.New(cors.Options{
-		AllowedOrigins:   []string{c.FrontEndServer},
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodOptions, http.MethodDelete, http.MethodPut},
-		AllowCredentials: true})
+	serverConfig.Cors = *cors.New(corsOptions(c))
 
 	rBase := mux.NewRouter().StrictSlash(true)
-	rBase.Use(Logger)
+	rBase.Use(RequestID)
+	rBase.Use(NewLogger(c.LogFormat))
 
 	if c.StartWebUI {
+		if c.UIDistPath != "" && c.WebUIProxy != "" {
+			return nil, fmt.Errorf("UIDistPath and WebUIProxy are mutually exclusive")
+		}
+
 		rUi := rBase.Methods("GET").PathPrefix("/ui/").Subrouter()
-		rUi.Methods("GET").Handler(http.StripPrefix("/ui/", http.FileServer(http.Dir(c.UIDistPath))))
+		if c.WebUIProxy != "" {
+			proxy, err := newUIProxy(c.WebUIProxy)
+			if err != nil {
+				return nil, fmt.Errorf("configuring webui_proxy: %w", err)
+			}
+			rUi.Methods("GET").Handler(http.StripPrefix("/ui/", proxy))
+		} else {
+			rUi.Methods("GET").Handler(http.StripPrefix("/ui/", http.FileServer(http.Dir(c.UIDistPath))))
+		}
 	}
 
 	if c.StartRestApi {
@@ -109,5 +411,144 @@ func Serve(c *WebConfig, serveConfig *ServeConfig) {
 		restapiweb.SetupRouter(rApi, &serverConfig)
 	}
 
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(c.LocalPort), rBase))
+	if c.StartGraphQL {
+		if serveConfig.GraphQLSchema == nil {
+			return nil, fmt.Errorf("start_graphql requires ServeConfig.GraphQLSchema")
+		}
+		gqlSrv := handler.NewDefaultServer(serveConfig.GraphQLSchema)
+		rGraphql := rBase.Methods("GET", "POST", "OPTIONS").Path("/graphql").Subrouter()
+		rGraphql.Use(serverConfig.Cors.Handler)
+		rGraphql.Handler(gqlSrv)
+
+		if c.StartPlayground {
+			rBase.Methods("GET").Path("/playground").Handler(playground.Handler("ADK GraphQL playground", "/graphql"))
+		}
+	}
+
+	return rBase, nil
+}
+
+// newUIProxy builds a reverse proxy forwarding requests to target, preserving
+// Upgrade headers for WebSocket connections and flushing promptly so SSE-style
+// responses stream rather than buffer.
+func newUIProxy(target string) (*httputil.ReverseProxy, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parsing webui_proxy URL: %w", err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.FlushInterval = -1
+	return proxy, nil
+}
+
+// Serve initiates the http server and runs it according to WebConfig
+// parameters until ctx is canceled or a SIGINT/SIGTERM is received, at which
+// point it drains in-flight requests before returning. It returns nil on a
+// clean shutdown, or the error that caused the server to stop otherwise.
+func Serve(ctx context.Context, c *WebConfig, serveConfig *ServeConfig) error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLSCertFile and TLSKeyFile must both be set to serve TLS, or both left empty")
+	}
+
+	rBase, err := buildRouter(c, serveConfig)
+	if err != nil {
+		return err
+	}
+
+	host := c.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	srv := &http.Server{
+		Handler:           rBase,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+	}
+
+	var certManager *autocert.Manager
+	if len(c.AutoCertDomains) > 0 {
+		certManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutoCertDomains...),
+		}
+		if c.AutoCertCacheDir != "" {
+			certManager.Cache = autocert.DirCache(c.AutoCertCacheDir)
+		}
+		srv.TLSConfig = certManager.TLSConfig()
+	}
+	useTLS := certManager != nil || (c.TLSCertFile != "" && c.TLSKeyFile != "")
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(c.LocalPort)))
+	if err != nil {
+		return fmt.Errorf("listening on %s:%d: %w", host, c.LocalPort, err)
+	}
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/ui/", scheme, listener.Addr().String())
+	log.Printf("listening on %s", url)
+	if c.Open && c.StartWebUI {
+		if err := openBrowser(url); err != nil {
+			log.Printf("failed to open browser: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		switch {
+		case certManager != nil:
+			err = srv.ServeTLS(listener, "", "")
+		case c.TLSCertFile != "" && c.TLSKeyFile != "":
+			err = srv.ServeTLS(listener, c.TLSCertFile, c.TLSKeyFile)
+		default:
+			err = srv.Serve(listener)
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownTimeout := c.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down server: %w", err)
+	}
+	return <-errCh
+}
+
+// openBrowser launches the user's default browser at url.
+func openBrowser(url string) error {
+	var cmd string
+	var args []string
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd, args = "open", []string{url}
+	case "windows":
+		cmd, args = "rundll32", []string{"url.dll,FileProtocolHandler", url}
+	default:
+		cmd, args = "xdg-open", []string{url}
+	}
+	return exec.Command(cmd, args...).Start()
 }